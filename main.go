@@ -15,7 +15,7 @@ func main() {
 	mux := http.NewServeMux()
 
 	upgrader := ws.Upgrader{
-		Compress: true,
+		EnableCompression: true,
 	}
 
 	clis := []*ws.Conn{}
@@ -0,0 +1,90 @@
+//go:build autobahn
+
+// Package autobahn drives the Autobahn TestSuite fuzzingclient against
+// this module's WebSocket implementation via the crossbario/autobahn-testsuite
+// Docker image, and fails if any reported case isn't OK or NON-STRICT.
+// It requires Docker and is excluded from the default build; run it
+// with:
+//
+//	go test -tags autobahn ./test/autobahn/...
+package autobahn
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const fuzzingClientImage = "crossbario/autobahn-testsuite"
+
+// caseResult mirrors the entries in reports/servers/index.json that
+// wstest writes for each case it runs against an agent.
+type caseResult struct {
+	Behavior      string `json:"behavior"`
+	BehaviorClose string `json:"behaviorClose"`
+}
+
+func TestAutobahnConformance(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to resolve working directory: %v", err)
+	}
+
+	reportsDir := filepath.Join(dir, "reports")
+	if err := os.RemoveAll(reportsDir); err != nil {
+		t.Fatalf("failed to clear previous reports: %v", err)
+	}
+
+	serverBin := filepath.Join(t.TempDir(), "autobahn-echo-server")
+
+	build := exec.Command("go", "build", "-o", serverBin, "./server")
+	build.Dir = dir
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build the echo server: %v\n%s", err, out)
+	}
+
+	server := exec.Command(serverBin, "-addr", ":9001")
+	if err := server.Start(); err != nil {
+		t.Fatalf("failed to start the echo server: %v", err)
+	}
+	defer server.Process.Kill()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	run := exec.CommandContext(ctx, "docker", "run",
+		"--rm",
+		"--network=host",
+		"-v", dir+":/config",
+		"-v", reportsDir+":/config/reports",
+		fuzzingClientImage,
+		"wstest", "-m", "fuzzingclient", "-s", "/config/fuzzingclient.json",
+	)
+	if out, err := run.CombinedOutput(); err != nil {
+		t.Fatalf("fuzzingclient run failed: %v\n%s", err, out)
+	}
+
+	report, err := os.ReadFile(filepath.Join(reportsDir, "servers", "index.json"))
+	if err != nil {
+		t.Fatalf("failed to read the fuzzingclient report: %v", err)
+	}
+
+	var results map[string]map[string]caseResult
+	if err := json.Unmarshal(report, &results); err != nil {
+		t.Fatalf("failed to parse the fuzzingclient report: %v", err)
+	}
+
+	for agent, cases := range results {
+		for id, result := range cases {
+			switch result.Behavior {
+			case "OK", "NON-STRICT":
+			default:
+				t.Errorf("%s case %s: %s", agent, id, result.Behavior)
+			}
+		}
+	}
+}
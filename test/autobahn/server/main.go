@@ -0,0 +1,53 @@
+// Command autobahn-echo-server is the system under test for the
+// Autobahn TestSuite fuzzingclient: a minimal WebSocket echo server
+// built on this module, with compression enabled so the fuzzingclient's
+// permessage-deflate cases are exercised too.
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/senicko/go-ws/ws"
+)
+
+func main() {
+	addr := flag.String("addr", ":9001", "address to listen on")
+	flag.Parse()
+
+	upgrader := ws.Upgrader{EnableCompression: true}
+
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r)
+		if err != nil {
+			log.Println("upgrade failed:", err)
+			return
+		}
+		defer conn.Close()
+
+		for {
+			opcode, msg, err := conn.NextReader()
+			if err != nil {
+				return
+			}
+
+			wr, err := conn.NextWriter(opcode)
+			if err != nil {
+				return
+			}
+
+			if _, err := io.Copy(wr, msg); err != nil {
+				return
+			}
+
+			if err := wr.Close(); err != nil {
+				return
+			}
+		}
+	})
+
+	log.Printf("autobahn echo server listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
@@ -0,0 +1,252 @@
+package ws
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Standard channel.k8s.io / base64.channel.k8s.io channel indices, as
+// used by Kubernetes exec/attach streams.
+const (
+	ChannelStdin  = 0
+	ChannelStdout = 1
+	ChannelStderr = 2
+	ChannelError  = 3
+	ChannelResize = 4
+)
+
+// ChannelConn is one multiplexed stream of a channel.k8s.io-style Conn,
+// produced by Conn.AsChannels or Conn.AsBase64Channels. Reads and
+// writes only ever see that channel's own payload; the channel byte
+// itself is added and stripped transparently.
+type ChannelConn struct {
+	conn    *Conn
+	channel byte
+	base64  bool
+	writeMu *sync.Mutex
+
+	incoming chan []byte
+	buf      []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Read implements io.Reader.
+func (cc *ChannelConn) Read(p []byte) (int, error) {
+	for len(cc.buf) == 0 {
+		select {
+		case data, ok := <-cc.incoming:
+			if !ok {
+				return 0, io.EOF
+			}
+			cc.buf = data
+		case <-cc.closed:
+			return 0, io.EOF
+		}
+	}
+
+	n := copy(p, cc.buf)
+	cc.buf = cc.buf[n:]
+
+	return n, nil
+}
+
+// Write implements io.Writer, sending p as a single message on this
+// channel. writeMu is shared by every ChannelConn produced from the
+// same Conn, since they all write to the same underlying connection.
+func (cc *ChannelConn) Write(p []byte) (int, error) {
+	opcode, payload := encodeChannelFrame(cc.channel, p, cc.base64)
+
+	cc.writeMu.Lock()
+	err := cc.conn.WriteMessage(opcode, payload)
+	cc.writeMu.Unlock()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close implements io.Closer. It only stops delivering reads for this
+// channel; the underlying Conn is shared with the other channels and
+// is unaffected.
+func (cc *ChannelConn) Close() error {
+	cc.closeOnce.Do(func() { close(cc.closed) })
+	return nil
+}
+
+// channelDemux reads frames off a Conn and dispatches their payload to
+// the ChannelConn selected by the channel byte, so n independent
+// io.ReadWriteClosers can share one underlying WebSocket connection.
+type channelDemux struct {
+	conn    *Conn
+	base64  bool
+	writeMu sync.Mutex
+	chans   []*ChannelConn
+}
+
+func newChannelDemux(conn *Conn, n int, useBase64 bool) *channelDemux {
+	d := &channelDemux{conn: conn, base64: useBase64}
+
+	d.chans = make([]*ChannelConn, n)
+	for i := range d.chans {
+		d.chans[i] = &ChannelConn{
+			conn:     conn,
+			channel:  byte(i),
+			base64:   useBase64,
+			writeMu:  &d.writeMu,
+			incoming: make(chan []byte, 16),
+			closed:   make(chan struct{}),
+		}
+	}
+
+	go d.run()
+
+	return d
+}
+
+func (d *channelDemux) channels() []io.ReadWriteCloser {
+	out := make([]io.ReadWriteCloser, len(d.chans))
+	for i, cc := range d.chans {
+		out[i] = cc
+	}
+	return out
+}
+
+// run reads frames from the underlying Conn until it errors, handing
+// each one's payload to the channel it names. Malformed frames and
+// frames naming an out-of-range channel are dropped rather than
+// killing the whole multiplex.
+func (d *channelDemux) run() {
+	defer func() {
+		for _, cc := range d.chans {
+			close(cc.incoming)
+		}
+	}()
+
+	for {
+		_, r, err := d.conn.NextReader()
+		if err != nil {
+			return
+		}
+
+		payload, err := io.ReadAll(r)
+		if err != nil {
+			return
+		}
+
+		channel, data, err := decodeChannelFrame(payload, d.base64)
+		if err != nil || int(channel) >= len(d.chans) {
+			continue
+		}
+
+		cc := d.chans[channel]
+		select {
+		case cc.incoming <- data:
+		case <-cc.closed:
+		}
+	}
+}
+
+// AsChannels treats c as a channel.k8s.io-style multiplexed stream
+// with n channels: every binary frame's first payload byte selects the
+// channel its remaining payload belongs to. It starts a background
+// goroutine that demultiplexes incoming frames and returns one
+// io.ReadWriteCloser per channel; writing to channel i sends a binary
+// message whose first payload byte is i.
+func (c *Conn) AsChannels(n int) []io.ReadWriteCloser {
+	return newChannelDemux(c, n, false).channels()
+}
+
+// AsBase64Channels is AsChannels for the base64.channel.k8s.io variant:
+// frames are OpText, the channel is an ASCII digit ('0'+channel)
+// instead of a raw byte, and the rest of the payload is base64-encoded.
+func (c *Conn) AsBase64Channels(n int) []io.ReadWriteCloser {
+	return newChannelDemux(c, n, true).channels()
+}
+
+// decodeChannelFrame splits a channel frame's payload into its channel
+// byte and data, undoing the base64 encoding the base64.channel.k8s.io
+// variant applies.
+func decodeChannelFrame(payload []byte, useBase64 bool) (channel byte, data []byte, err error) {
+	if len(payload) == 0 {
+		return 0, nil, fmt.Errorf("empty channel frame")
+	}
+
+	if !useBase64 {
+		return payload[0], payload[1:], nil
+	}
+
+	data, err = base64.StdEncoding.DecodeString(string(payload[1:]))
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to decode base64 channel frame: %w", err)
+	}
+
+	return payload[0] - '0', data, nil
+}
+
+// encodeChannelFrame builds the opcode and payload of a channel frame
+// carrying data on channel, applying the base64.channel.k8s.io
+// encoding when useBase64 is set.
+func encodeChannelFrame(channel byte, data []byte, useBase64 bool) (opcode uint8, payload []byte) {
+	if !useBase64 {
+		return OpBinary, append([]byte{channel}, data...)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	return OpText, append([]byte{'0' + channel}, encoded...)
+}
+
+// Proxy shuttles frames between client and upstream in both directions
+// until either side errors or closes, at which point it closes both
+// connections and returns the error that ended the first direction.
+// When translate is non-nil, every binary frame's channel byte and
+// payload are passed through it before being forwarded, so a gateway
+// can adapt between multiplexed-stream subprotocol dialects (e.g.
+// channel.k8s.io upstream to a different dialect downstream) without
+// decoding and re-encoding frames itself.
+func Proxy(client, upstream *Conn, translate func(channel byte, payload []byte) (byte, []byte, error)) error {
+	errs := make(chan error, 2)
+
+	go func() { errs <- proxyDirection(client, upstream, translate) }()
+	go func() { errs <- proxyDirection(upstream, client, translate) }()
+
+	err := <-errs
+	client.Close()
+	upstream.Close()
+	<-errs
+
+	return err
+}
+
+// proxyDirection copies frames read from src to dst until src errors,
+// applying translate to binary frames' channel byte and payload first.
+func proxyDirection(dst, src *Conn, translate func(channel byte, payload []byte) (byte, []byte, error)) error {
+	for {
+		opcode, r, err := src.NextReader()
+		if err != nil {
+			return err
+		}
+
+		payload, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+
+		if opcode == OpBinary && translate != nil && len(payload) > 0 {
+			channel, newPayload, err := translate(payload[0], payload[1:])
+			if err != nil {
+				return err
+			}
+			payload = append([]byte{channel}, newPayload...)
+		}
+
+		if err := dst.WriteMessage(opcode, payload); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestAsChannelsDispatchesByChannelByte(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ws := &Conn{
+		conn:     server,
+		reader:   bufio.NewReaderSize(server, 1024),
+		writeBuf: make([]byte, 1024),
+	}
+	defer ws.Close()
+
+	channels := ws.AsChannels(2)
+
+	stdout := []byte("hello")
+	maskedPayload, maskingKey, err := maskPayload(append([]byte{ChannelStdout}, stdout...))
+	if err != nil {
+		t.Fatal("failed to prepare masked payload", err)
+	}
+
+	frame := []byte{0x82, 0x80 | byte(len(maskedPayload))}
+	frame = append(frame, maskingKey...)
+	frame = append(frame, maskedPayload...)
+
+	go func() {
+		if _, err := client.Write(frame); err != nil {
+			t.Error("failed to write to the WebSocket", err)
+		}
+	}()
+
+	received := make([]byte, len(stdout))
+	if _, err := channels[ChannelStdout].Read(received); err != nil {
+		t.Fatal("failed to read from the stdout channel", err)
+	}
+
+	if !bytes.Equal(stdout, received) {
+		t.Errorf("expected %s, got %s", stdout, received)
+	}
+}
+
+func TestChannelConnWriteTagsChannelByte(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ws := &Conn{
+		conn:     server,
+		reader:   bufio.NewReaderSize(server, 1024),
+		writeBuf: make([]byte, 1024),
+	}
+	defer ws.Close()
+
+	channels := ws.AsChannels(3)
+
+	go func() {
+		if _, err := channels[ChannelStderr].Write([]byte("oops")); err != nil {
+			t.Error("failed to write to the stderr channel", err)
+		}
+	}()
+
+	header := make([]byte, 2)
+	if _, err := client.Read(header); err != nil {
+		t.Fatal("failed to read the frame header", err)
+	}
+
+	payloadLength := int(header[1] & 0x7f)
+	payload := make([]byte, payloadLength)
+	if _, err := client.Read(payload); err != nil {
+		t.Fatal("failed to read the frame payload", err)
+	}
+
+	if payload[0] != ChannelStderr {
+		t.Errorf("expected channel byte %d, got %d", ChannelStderr, payload[0])
+	}
+
+	if !bytes.Equal(payload[1:], []byte("oops")) {
+		t.Errorf("expected %s, got %s", "oops", payload[1:])
+	}
+}
@@ -0,0 +1,107 @@
+package ws
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// WebSocket close status codes.
+// https://www.rfc-editor.org/rfc/rfc6455#section-7.4
+const (
+	CloseStatusNormalClosure           uint16 = 1000
+	CloseStatusGoingAway               uint16 = 1001
+	CloseStatusProtocolError           uint16 = 1002
+	CloseStatusUnsupportedData         uint16 = 1003
+	CloseStatusInvalidFramePayloadData uint16 = 1007
+	CloseStatusPolicyViolation         uint16 = 1008
+	CloseStatusMessageTooBig           uint16 = 1009
+	CloseStatusMandatoryExtension      uint16 = 1010
+	CloseStatusInternalServerErr       uint16 = 1011
+
+	// CloseStatusNoStatusReceived, CloseStatusAbnormalClosure and
+	// CloseStatusTLSHandshake are reserved: a peer must never actually
+	// send them in a Close frame. They exist so this package can report
+	// those conditions through the same CloseError type.
+	CloseStatusNoStatusReceived uint16 = 1005
+	CloseStatusAbnormalClosure  uint16 = 1006
+	CloseStatusTLSHandshake     uint16 = 1015
+)
+
+// isValidCloseStatusCode reports whether code is legal for a peer to
+// send on the wire in a Close frame.
+// https://www.rfc-editor.org/rfc/rfc6455#section-7.4.1
+func isValidCloseStatusCode(code uint16) bool {
+	switch code {
+	case CloseStatusNormalClosure,
+		CloseStatusGoingAway,
+		CloseStatusProtocolError,
+		CloseStatusUnsupportedData,
+		CloseStatusInvalidFramePayloadData,
+		CloseStatusPolicyViolation,
+		CloseStatusMessageTooBig,
+		CloseStatusMandatoryExtension,
+		CloseStatusInternalServerErr:
+		return true
+	}
+
+	// 3000-4999 are reserved for use by libraries, frameworks and
+	// applications, and can't be registered or enumerated up front.
+	return code >= 3000 && code <= 4999
+}
+
+// CloseError is returned when a connection is failed because of a
+// WebSocket protocol violation, such as an invalid close status code or
+// a text message that isn't valid UTF-8. Code and Text describe the
+// close status this package sent (best effort) before tearing down the
+// connection.
+type CloseError struct {
+	Code uint16
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d: %s", e.Code, e.Text)
+}
+
+// utf8Validator incrementally validates that the bytes passed to
+// validate form valid UTF-8, across any number of calls. This lets a
+// fragmented text message be validated fragment by fragment as it
+// arrives instead of being buffered whole first. finish must be called
+// once the message is complete to reject a trailing incomplete
+// sequence.
+type utf8Validator struct {
+	pending []byte // bytes of a rune that may be completed by a later call
+}
+
+// validate reports whether p, taken together with any pending bytes
+// left over from a previous call, is valid UTF-8 so far.
+func (v *utf8Validator) validate(p []byte) bool {
+	buf := append(v.pending, p...)
+	v.pending = nil
+
+	for len(buf) > 0 {
+		r, size := utf8.DecodeRune(buf)
+		if r != utf8.RuneError || size > 1 {
+			buf = buf[size:]
+			continue
+		}
+
+		if utf8.FullRune(buf) {
+			// A complete, invalid sequence.
+			return false
+		}
+
+		// An incomplete sequence at the end of buf: it may be completed
+		// by the next call, so carry it over instead of rejecting it.
+		v.pending = append([]byte(nil), buf...)
+		return true
+	}
+
+	return true
+}
+
+// finish reports whether the message ended cleanly, i.e. without a
+// truncated trailing sequence still pending.
+func (v *utf8Validator) finish() bool {
+	return len(v.pending) == 0
+}
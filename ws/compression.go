@@ -3,24 +3,54 @@ package ws
 import (
 	"bytes"
 	"compress/flate"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 )
 
-// TODO: This functionality could be implemented as Go's io.Reader and io.Writer
-
-// decompress decompresses message bytes as described in
+// deflateTail is appended to a message compressed without context
+// takeover to squelch the unexpected EOF a fresh flate.Reader would
+// otherwise report, as described in
 // https://www.rfc-editor.org/rfc/rfc7692.html#section-7.2.2
+const deflateTail = "\x00\x00\xff\xff" + "\x01\x00\x00\xff\xff"
+
+// syncFlushTail is the legitimate 4-byte marker a sender strips after a
+// Z_SYNC_FLUSH. It must be used instead of deflateTail whenever the
+// flate.Reader needs to stay alive to decode a later message, i.e.
+// whenever read-side context takeover is enabled.
+const syncFlushTail = "\x00\x00\xff\xff"
+
+// flateReaderPool and flateWriterPool amortize allocation for
+// connections that negotiated permessage-deflate without context
+// takeover, where a fresh (de)compressor is needed for every message.
+var (
+	flateReaderPool = sync.Pool{
+		New: func() any {
+			return flate.NewReader(strings.NewReader(deflateTail))
+		},
+	}
+
+	flateWriterPool = sync.Pool{
+		New: func() any {
+			fw, _ := flate.NewWriter(io.Discard, flate.BestCompression)
+			return fw
+		},
+	}
+)
+
+// decompress inflates message bytes compressed as described in
+// https://www.rfc-editor.org/rfc/rfc7692.html#section-7.2.2, using a
+// reader drawn from flateReaderPool. Used when context takeover is
+// disabled, so every message can be decoded independently of the rest.
 func decompress(m []byte) ([]byte, error) {
-	const tail =
-	// Add four bytes as specified in RFC
-	"\x00\x00\xff\xff" +
-		// Add final block to squelch unexpected EOF error from flate reader.
-		"\x01\x00\x00\xff\xff"
+	fr := flateReaderPool.Get().(io.Reader)
+	defer flateReaderPool.Put(fr)
 
-	fr := flate.NewReader(io.MultiReader(bytes.NewReader(m), strings.NewReader(tail)))
-	defer fr.Close()
+	if err := fr.(flate.Resetter).Reset(io.MultiReader(bytes.NewReader(m), strings.NewReader(deflateTail)), nil); err != nil {
+		return nil, fmt.Errorf("failed to reset flate reader: %w", err)
+	}
 
 	decompressed, err := io.ReadAll(fr)
 	if err != nil {
@@ -30,12 +60,15 @@ func decompress(m []byte) ([]byte, error) {
 	return decompressed, nil
 }
 
-// compress compresses message bytes as described in
-// https://www.rfc-editor.org/rfc/rfc7692#section-7.2.1
+// compress deflates message bytes as described in
+// https://www.rfc-editor.org/rfc/rfc7692#section-7.2.1, using a writer
+// drawn from flateWriterPool. Used when context takeover is disabled.
 func compress(m []byte) ([]byte, error) {
-	var compressed bytes.Buffer
+	fw := flateWriterPool.Get().(*flate.Writer)
+	defer flateWriterPool.Put(fw)
 
-	fw, _ := flate.NewWriter(&compressed, flate.BestCompression)
+	var compressed bytes.Buffer
+	fw.Reset(&compressed)
 
 	if _, err := fw.Write(m); err != nil {
 		return nil, fmt.Errorf("failed to write message bytes: %w", err)
@@ -45,10 +78,170 @@ func compress(m []byte) ([]byte, error) {
 		return nil, fmt.Errorf("failed to flush the message: %w", err)
 	}
 
-	if err := fw.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close the flate writer: %w", err)
+	compressedBytes := compressed.Bytes()
+	return append([]byte(nil), compressedBytes[:len(compressedBytes)-4]...), nil
+}
+
+// permessageDeflateParams holds the parameters of a negotiated
+// permessage-deflate extension, from the perspective of whichever side
+// parsed them.
+type permessageDeflateParams struct {
+	serverNoContextTakeover bool
+	clientNoContextTakeover bool
+}
+
+// parsePermessageDeflateExtension parses a single extension value from
+// a Sec-WebSocket-Extensions header into its permessage-deflate
+// parameters. ok is false when ext isn't a permessage-deflate offer.
+func parsePermessageDeflateExtension(ext string) (params permessageDeflateParams, ok bool) {
+	parts := strings.Split(ext, ";")
+	if strings.TrimSpace(parts[0]) != "permessage-deflate" {
+		return params, false
 	}
 
-	compressedBytes := compressed.Bytes()
-	return compressedBytes[:len(compressedBytes)-4], nil
+	for _, p := range parts[1:] {
+		switch strings.TrimSpace(p) {
+		case "server_no_context_takeover":
+			params.serverNoContextTakeover = true
+		case "client_no_context_takeover":
+			params.clientNoContextTakeover = true
+		}
+	}
+
+	return params, true
+}
+
+// findPermessageDeflateExtension scans a Sec-WebSocket-Extensions
+// header value for a permessage-deflate offer or acceptance.
+func findPermessageDeflateExtension(header string) (permessageDeflateParams, bool) {
+	for _, ext := range strings.Split(header, ",") {
+		if params, ok := parsePermessageDeflateExtension(ext); ok {
+			return params, true
+		}
+	}
+
+	return permessageDeflateParams{}, false
+}
+
+// formatPermessageDeflateExtension renders params as the value of a
+// Sec-WebSocket-Extensions header accepting permessage-deflate.
+func formatPermessageDeflateExtension(params permessageDeflateParams) string {
+	parts := []string{"permessage-deflate"}
+
+	if params.serverNoContextTakeover {
+		parts = append(parts, "server_no_context_takeover")
+	}
+	if params.clientNoContextTakeover {
+		parts = append(parts, "client_no_context_takeover")
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// takeoverSource feeds one message's compressed bytes at a time to a
+// long-lived flate.Reader, and keeps the LZ77 window (hist) that the
+// next message may refer back to under context takeover.
+type takeoverSource struct {
+	cur  []byte
+	hist []byte // last 32KB of decompressed output
+}
+
+func (s *takeoverSource) Read(p []byte) (int, error) {
+	if len(s.cur) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(p, s.cur)
+	s.cur = s.cur[n:]
+
+	return n, nil
+}
+
+// configureCompression stores the permessage-deflate parameters
+// negotiated during the handshake and, for whichever side keeps its
+// context across messages, sets up the long-lived (de)compressor that
+// makes that possible. ownNoContextTakeover/peerNoContextTakeover are
+// from this Conn's point of view: own governs frames it sends, peer
+// governs frames it receives.
+func (c *Conn) configureCompression(level int, ownNoContextTakeover, peerNoContextTakeover bool) {
+	c.compression = true
+	c.writeCompression = true
+
+	if level == 0 {
+		level = flate.BestCompression
+	}
+
+	if !ownNoContextTakeover {
+		c.compressBuf = &bytes.Buffer{}
+		c.flateWriter, _ = flate.NewWriter(c.compressBuf, level)
+	}
+
+	if !peerNoContextTakeover {
+		c.decompressSrc = &takeoverSource{}
+		c.flateReader = flate.NewReader(c.decompressSrc)
+	}
+}
+
+// compressMessage deflates m, using this Conn's long-lived compressor
+// when write-side context takeover is enabled, or a pooled one-shot
+// writer otherwise.
+func (c *Conn) compressMessage(m []byte) ([]byte, error) {
+	if c.flateWriter == nil {
+		return compress(m)
+	}
+
+	c.compressBuf.Reset()
+
+	if _, err := c.flateWriter.Write(m); err != nil {
+		return nil, fmt.Errorf("failed to write message bytes: %w", err)
+	}
+
+	if err := c.flateWriter.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to flush the message: %w", err)
+	}
+
+	b := c.compressBuf.Bytes()
+	return append([]byte(nil), b[:len(b)-4]...), nil
+}
+
+// decompressMessage inflates m, using this Conn's long-lived
+// decompressor when read-side context takeover is enabled, or a pooled
+// one-shot reader otherwise.
+func (c *Conn) decompressMessage(m []byte) ([]byte, error) {
+	if c.flateReader == nil {
+		return decompress(m)
+	}
+
+	c.decompressSrc.cur = append(append([]byte(nil), m...), syncFlushTail...)
+
+	// The reader keeps the EOF it hit at the end of the previous message,
+	// so restart it on this one, seeded with the window left behind.
+	if err := c.flateReader.(flate.Resetter).Reset(c.decompressSrc, c.decompressSrc.hist); err != nil {
+		return nil, fmt.Errorf("failed to reset flate reader: %w", err)
+	}
+
+	var out bytes.Buffer
+	buf := make([]byte, 4096)
+
+	for {
+		n, err := c.flateReader.Read(buf)
+		if n > 0 {
+			out.Write(buf[:n])
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to decompress the message: %w", err)
+		}
+	}
+
+	hist := append(c.decompressSrc.hist, out.Bytes()...)
+	if len(hist) > 1<<15 {
+		hist = append([]byte(nil), hist[len(hist)-1<<15:]...)
+	}
+	c.decompressSrc.hist = hist
+
+	return out.Bytes(), nil
 }
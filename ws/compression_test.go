@@ -0,0 +1,57 @@
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestDecompressMessageLargePayload(t *testing.T) {
+	c := &Conn{}
+	c.configureCompression(0, false, false)
+
+	payload := bytes.Repeat([]byte("a"), 40000)
+
+	compressed, err := c.compressMessage(payload)
+	if err != nil {
+		t.Fatal("failed to compress the message", err)
+	}
+
+	decompressed, err := c.decompressMessage(compressed)
+	if err != nil {
+		t.Fatal("failed to decompress the message", err)
+	}
+
+	if !bytes.Equal(payload, decompressed) {
+		t.Errorf("expected a round trip of %d bytes, got %d", len(payload), len(decompressed))
+	}
+}
+
+func TestWriteMessageDoesNotCompressControlFrames(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ws := Conn{
+		conn:     server,
+		reader:   bufio.NewReaderSize(server, 1024),
+		writeBuf: make([]byte, 1024),
+	}
+	defer ws.Close()
+	ws.configureCompression(0, false, false)
+
+	go func() {
+		if err := ws.WriteMessage(OpPong, nil); err != nil {
+			t.Error("failed to write the pong frame", err)
+		}
+	}()
+
+	header := make([]byte, 2)
+	if _, err := client.Read(header); err != nil {
+		t.Fatal("failed to read the frame header", err)
+	}
+
+	if header[0]&bitRsv1 != 0 {
+		t.Errorf("expected RSV1 unset on a control frame, got %#x", header[0])
+	}
+}
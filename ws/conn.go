@@ -2,12 +2,19 @@ package ws
 
 import (
 	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -30,13 +37,6 @@ const (
 	OpPong   = 10
 )
 
-// WebSocket connection close status codes.
-// https://www.rfc-editor.org/rfc/rfc6455#section-11.7
-const (
-	CloseStatusNoStatusReceived uint16 = 1005
-	CloseStatusProtocolError    uint16 = 1002
-)
-
 var (
 	ErrProtocolError    = errors.New("protocol error")
 	ErrConnectionClosed = errors.New("connection has been closed")
@@ -82,8 +82,10 @@ func (f *frame) validate() error {
 		if !f.fin {
 			errors = append(errors, "control frame FIN not set to 1")
 		}
-	case OpText, OpBinary:
-		// TODO: Validate for final read when fragmentation will be supported
+	case OpText, OpBinary, 0:
+		// 0 is the continuation opcode used by every fragment after the
+		// first in a fragmented message; nextDataFrame enforces that it
+		// only ever shows up where a continuation is actually expected.
 	default:
 		errors = append(errors, "unknown opcode")
 	}
@@ -97,18 +99,70 @@ func (f *frame) validate() error {
 
 // Conn represents a WebSocket connection.
 type Conn struct {
-	conn        net.Conn
-	compression bool
-	writeBuf    []byte
-	reader      *bufio.Reader
+	conn     net.Conn
+	writeBuf []byte
+	reader   *bufio.Reader
+
+	// mask reports whether this side of the connection is the client.
+	// Clients must mask every frame they send and must receive only
+	// unmasked frames; servers are the opposite.
+	// https://www.rfc-editor.org/rfc/rfc6455#section-5.3
+	mask bool
+
+	// subprotocol is the value negotiated during the handshake, if any.
+	subprotocol string
+
+	// compression reports whether permessage-deflate was negotiated for
+	// this connection. writeCompression additionally reports whether
+	// outgoing messages are currently being compressed; it defaults to
+	// compression but can be toggled off per-message via
+	// EnableWriteCompression.
+	compression      bool
+	writeCompression bool
+
+	// flateWriter/compressBuf are set when write-side context takeover
+	// is enabled: the same flate.Writer is reused for the lifetime of
+	// the connection instead of resetting it for every message, so its
+	// compression window carries over. flateReader/decompressSrc are
+	// the read-side equivalent. When takeover is disabled for a given
+	// direction, the corresponding field is left nil and compress/
+	// decompress fall back to the pooled one-shot (de)compressors.
+	flateWriter   *flate.Writer
+	compressBuf   *bytes.Buffer
+	flateReader   io.Reader
+	decompressSrc *takeoverSource
+
+	// pingHandler/pongHandler are invoked by nextFrame when the
+	// corresponding control frame is received. nil means the default
+	// behavior described on SetPingHandler/SetPongHandler.
+	pingHandler func(appData []byte) error
+	pongHandler func(appData []byte) error
+
+	// lastPong is the UnixNano time of the last Pong frame seen,
+	// updated regardless of pongHandler so the keepalive goroutine
+	// started by SetPingPeriod can judge liveness independently of
+	// whatever the caller's pong handler does.
+	lastPong atomic.Int64
+
+	// stopKeepalive, when non-nil, signals the keepalive goroutine
+	// started by SetPingPeriod to exit.
+	stopKeepalive chan struct{}
+
+	// writeMu serializes WriteMessage calls, so the keepalive goroutine
+	// started by SetPingPeriod can't interleave a Ping frame with an
+	// application write on the wire, and the two can't race over the
+	// shared flateWriter/compressBuf.
+	writeMu sync.Mutex
 }
 
-// NewConn returns a new Conn.
-func newConn(conn net.Conn, readBufferSize int, writeBufferSize int) *Conn {
+// newConn returns a new Conn. mask reports whether outgoing frames must be
+// masked, i.e. whether this Conn plays the client role.
+func newConn(conn net.Conn, readBufferSize int, writeBufferSize int, mask bool) *Conn {
 	return &Conn{
 		conn:     conn,
 		reader:   bufio.NewReaderSize(conn, readBufferSize),
 		writeBuf: make([]byte, writeBufferSize),
+		mask:     mask,
 	}
 }
 
@@ -117,64 +171,253 @@ func (c *Conn) Close() {
 	c.conn.Close()
 }
 
-// ReadMessage returns payload from inoming WebSocket frame.
+// ReadMessage reads a full message into memory and returns its payload.
+// It is a thin wrapper over NextReader for callers that don't need to
+// stream large payloads.
 func (c *Conn) ReadMessage() ([]byte, error) {
-	compressed := false
-	buf := []byte{}
+	_, r, err := c.NextReader()
+	if err != nil {
+		return nil, err
+	}
 
-	for {
-		f, err := c.nextFrame()
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+
+	return buf, nil
+}
+
+// WriteMessage sends a message to the peer. It is a thin wrapper over
+// NextWriter for callers that already hold the whole message in memory.
+func (c *Conn) WriteMessage(opcode uint8, m []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	rsv1 := false
+
+	// Control frames must never be compressed or fragmented (RFC 6455
+	// §5.5), so leave them alone regardless of writeCompression.
+	if c.writeCompression && opcode < OpClose {
+		cm, err := c.compressMessage(m)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read frame: %w", err)
+			return fmt.Errorf("failed to compress: %w", err)
 		}
-		if f == nil {
-			continue
+		m = cm
+		rsv1 = true
+	}
+
+	w := c.newMessageWriter(opcode, rsv1)
+
+	if _, err := w.Write(m); err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// EnableWriteCompression toggles permessage-deflate for subsequent
+// WriteMessage calls, provided compression was negotiated during the
+// handshake; it has no effect otherwise. Use it to skip the deflate
+// overhead for messages that aren't worth compressing.
+func (c *Conn) EnableWriteCompression(enabled bool) {
+	c.writeCompression = enabled && c.compression
+}
+
+// messageReader implements io.Reader for a single incoming message. It
+// pulls one fragment at a time from Conn.nextFrame, so the caller only
+// ever needs to hold a single fragment in memory rather than the whole
+// message. When utf8 is set, every fragment's payload is validated as it
+// arrives, so text messages are rejected as soon as invalid UTF-8 shows
+// up rather than after the whole message has been read.
+type messageReader struct {
+	c    *Conn
+	utf8 *utf8Validator
+	buf  []byte
+	done bool
+}
+
+// setFrame validates and installs f as the fragment currently being
+// read, failing the connection if it breaks UTF-8 validation.
+func (r *messageReader) setFrame(f *frame) error {
+	if r.utf8 != nil {
+		if !r.utf8.validate(f.payload) || (f.fin && !r.utf8.finish()) {
+			return r.c.failConnection(CloseStatusInvalidFramePayloadData, "invalid UTF-8 in text message")
 		}
+	}
 
-		if f.rsv1 {
-			if !compressed {
-				compressed = true
-			} else {
-				// TODO: What should happen when RSV1 is repeated?
-				return nil, err
-			}
+	r.buf = f.payload
+	r.done = f.fin
+
+	return nil
+}
+
+// Read implements io.Reader.
+func (r *messageReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
 		}
 
-		if !f.fin {
-			buf = append(buf, f.payload...)
-			continue
+		f, err := r.c.nextDataFrame(true)
+		if err != nil {
+			return 0, err
+		}
+
+		if err := r.setFrame(f); err != nil {
+			return 0, err
 		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
 
-		buf = append(buf, f.payload...)
-		break
+	return n, nil
+}
+
+// NextReader returns the opcode and a reader for the next incoming
+// message. Unlike ReadMessage, the returned reader streams the message
+// frame by frame instead of buffering it whole, which matters for large
+// payloads. When the message was sent with permessage-deflate, it is
+// transparently inflated; in that case the whole message is read and
+// inflated up front, so it is held in memory, both compressed and
+// decompressed, and is not streamed.
+func (c *Conn) NextReader() (opcode uint8, r io.Reader, err error) {
+	f, err := c.nextDataFrame(false)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read frame: %w", err)
 	}
 
-	if !compressed {
-		return buf, nil
+	mr := &messageReader{c: c}
+	if f.opcode == OpText && !f.rsv1 {
+		mr.utf8 = &utf8Validator{}
+	}
+	if err := mr.setFrame(f); err != nil {
+		return 0, nil, err
+	}
+
+	if !f.rsv1 {
+		return f.opcode, mr, nil
 	}
 
-	buf, err := decompress(buf)
+	compressed, err := io.ReadAll(mr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decompress the message: %w", err)
+		return 0, nil, fmt.Errorf("failed to read frame: %w", err)
 	}
 
-	return buf, nil
+	decompressed, err := c.decompressMessage(compressed)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to decompress the message: %w", err)
+	}
+
+	if f.opcode == OpText {
+		v := &utf8Validator{}
+		if !v.validate(decompressed) || !v.finish() {
+			return 0, nil, c.failConnection(CloseStatusInvalidFramePayloadData, "invalid UTF-8 in text message")
+		}
+	}
+
+	return f.opcode, bytes.NewReader(decompressed), nil
 }
 
-// WriteMessage sends message to the client.
-func (c *Conn) WriteMessage(opcode uint8, m []byte) error {
+// messageWriter implements io.WriteCloser for a single outgoing message.
+// Writes accumulate in buf and are flushed as non-final frames once buf
+// grows past the connection's write buffer size, so the caller can
+// stream a payload of any size without it ever being held whole in
+// memory. Whatever remains in buf is flushed as the final (FIN) frame
+// when Close is called, so a message that never exceeds one chunk is
+// sent as a single wire frame.
+type messageWriter struct {
+	c       *Conn
+	opcode  uint8
+	rsv1    bool
+	started bool
+	closed  bool
+	buf     []byte
+}
+
+// Write implements io.Writer.
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("write to a closed NextWriter")
+	}
+
+	chunkSize := len(w.c.writeBuf)
+	if chunkSize == 0 {
+		chunkSize = len(p)
+	}
+
+	w.buf = append(w.buf, p...)
+
+	for chunkSize > 0 && len(w.buf) > chunkSize {
+		if err := w.writeFrame(w.buf[:chunkSize], false); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[chunkSize:]
+	}
+
+	return len(p), nil
+}
+
+// Close flushes whatever remains buffered as the final (FIN) frame of
+// the message. It must be called exactly once per message.
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	err := w.writeFrame(w.buf, true)
+	w.buf = nil
+
+	return err
+}
+
+// writeFrame writes payload as the next frame of the message, using
+// w.opcode for the first frame and the continuation opcode (0) for
+// every frame after that.
+func (w *messageWriter) writeFrame(payload []byte, fin bool) error {
+	opcode := w.opcode
+	if w.started {
+		opcode = 0
+	}
+	w.started = true
+
+	rsv1 := w.rsv1 && opcode == w.opcode
+	if err := w.c.writeFrame(opcode, payload, fin, rsv1); err != nil {
+		return fmt.Errorf("failed to write frame: %w", err)
+	}
+
+	return nil
+}
+
+// newMessageWriter returns a messageWriter for opcode. rsv1 sets RSV1 on
+// the message's first frame, marking its payload as compressed; callers are
+// responsible for actually compressing what they write when rsv1 is set.
+func (c *Conn) newMessageWriter(opcode uint8, rsv1 bool) *messageWriter {
+	return &messageWriter{c: c, opcode: opcode, rsv1: rsv1}
+}
+
+// NextWriter returns a writer for a new outgoing message with the given
+// opcode. The returned writer must be closed to flush the final (FIN)
+// frame; until then, writes may be split across several wire frames.
+func (c *Conn) NextWriter(opcode uint8) (io.WriteCloser, error) {
+	return c.newMessageWriter(opcode, false), nil
+}
+
+// writeFrame writes a single WebSocket frame to the connection, masking
+// the payload first when this Conn plays the client role.
+func (c *Conn) writeFrame(opcode uint8, m []byte, fin bool, rsv1 bool) error {
 	frame := make([]byte, 2)
 	frame[0] |= opcode
-	frame[0] |= bitFin
 
-	if c.compression {
-		frame[0] |= bitRsv1
+	if fin {
+		frame[0] |= bitFin
+	}
 
-		cm, err := compress(m)
-		if err != nil {
-			return fmt.Errorf("failed to compress: %w", err)
-		}
-		m = cm
+	if rsv1 {
+		frame[0] |= bitRsv1
 	}
 
 	payloadLen := len(m)
@@ -183,14 +426,24 @@ func (c *Conn) WriteMessage(opcode uint8, m []byte) error {
 		frame[1] |= byte(payloadLen)
 	} else if payloadLen <= 0xFFFF {
 		frame[1] |= 126
-		binary.BigEndian.PutUint16(frame, uint16(payloadLen))
+		frame = binary.BigEndian.AppendUint16(frame, uint16(payloadLen))
 	} else {
 		frame[1] |= 127
-		binary.BigEndian.PutUint64(frame, uint64(payloadLen))
+		frame = binary.BigEndian.AppendUint64(frame, uint64(payloadLen))
+	}
+
+	if c.mask {
+		frame[1] |= bitMask
+
+		maskingKey := make([]byte, 4)
+		if _, err := rand.Read(maskingKey); err != nil {
+			return fmt.Errorf("failed to generate masking key: %w", err)
+		}
+
+		frame = append(frame, maskingKey...)
+		m = applyMask(m, maskingKey)
 	}
 
-	// TODO: We probably don't want to do that. Payload can be really huge.
-	// In fact do we want it to be a byte slice instead og io.Reader or something?
 	frame = append(frame, m...)
 
 	if _, err := c.conn.Write(frame); err != nil {
@@ -211,6 +464,33 @@ func (c *Conn) advanceBytes(n uint64) ([]byte, error) {
 	return b, nil
 }
 
+// nextDataFrame returns the next data frame, transparently handling
+// and skipping past any control frames interleaved by nextFrame along
+// the way. continuation selects which opcode is valid here: false for
+// the first frame of a message (OpText/OpBinary), true for every frame
+// after that (the continuation opcode, 0). A frame of the wrong kind
+// fails the connection, per RFC 6455 §5.4.
+func (c *Conn) nextDataFrame(continuation bool) (*frame, error) {
+	for {
+		f, err := c.nextFrame()
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			continue
+		}
+
+		if isContinuation := f.opcode == 0; isContinuation != continuation {
+			if continuation {
+				return nil, c.failConnection(CloseStatusProtocolError, "expected a continuation frame")
+			}
+			return nil, c.failConnection(CloseStatusProtocolError, "unexpected continuation frame")
+		}
+
+		return f, nil
+	}
+}
+
 // nextFrame returns next message frames (OpText, OpBinary).
 // It intercepts control frames, which are processed seperately
 // and returns nil.
@@ -227,8 +507,10 @@ func (c *Conn) nextFrame() (*frame, error) {
 	opcode := uint8(b[0] & 0xf)
 	mask := b[1]&bitMask != 0
 
-	if !mask {
-		return nil, fmt.Errorf("frame not masked: %w", ErrProtocolError)
+	// A server must only receive masked frames, a client must only
+	// receive unmasked ones. https://www.rfc-editor.org/rfc/rfc6455#section-5.1
+	if mask == c.mask {
+		return nil, fmt.Errorf("unexpected frame mask bit: %w", ErrProtocolError)
 	}
 
 	payloadLength := uint64(b[1] & 0x7f)
@@ -249,19 +531,22 @@ func (c *Conn) nextFrame() (*frame, error) {
 		payloadLength = binary.BigEndian.Uint64(b)
 	}
 
-	maskingKey, err := c.advanceBytes(4)
-	if err != nil {
-		return nil, err
+	var maskingKey []byte
+	if mask {
+		maskingKey, err = c.advanceBytes(4)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	maskedPayload, err := c.advanceBytes(payloadLength)
+	payload, err := c.advanceBytes(payloadLength)
 	if err != nil {
 		return nil, err
 	}
 
-	payload := applyMask(maskedPayload, maskingKey)
-
-	fmt.Println(payload)
+	if mask {
+		payload = applyMask(payload, maskingKey)
+	}
 
 	f := &frame{
 		fin:           fin,
@@ -279,28 +564,50 @@ func (c *Conn) nextFrame() (*frame, error) {
 		return nil, fmt.Errorf("frame validation failed: %w", err)
 	}
 
-	// If frame is a control frame process it separately
-	if opcode != OpText && opcode != OpBinary {
-		switch opcode {
-		case OpPing:
-			if err := c.WriteMessage(OpPong, payload); err != nil {
-				return nil, err
+	// Control frames are processed separately; OpText, OpBinary and the
+	// continuation opcode (0) are data frames returned to the caller.
+	switch opcode {
+	case OpPing:
+		h := c.pingHandler
+		if h == nil {
+			h = func(appData []byte) error { return c.WriteMessage(OpPong, appData) }
+		}
+		if err := h(payload); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	case OpClose:
+		statusCode := CloseStatusNoStatusReceived
+		reason := ""
+
+		if f.payloadLength > 0 {
+			if f.payloadLength < 2 {
+				return nil, c.failConnection(CloseStatusProtocolError, "close frame payload too short for a status code")
 			}
-			return nil, nil
-		case OpClose:
-			statusCode := CloseStatusNoStatusReceived
-			reason := ""
-
-			if f.payloadLength > 0 {
-				statusCode = binary.BigEndian.Uint16(f.payload)
-				reason = string(f.payload[2:])
+
+			statusCode = binary.BigEndian.Uint16(f.payload)
+			reason = string(f.payload[2:])
+
+			if !isValidCloseStatusCode(statusCode) {
+				return nil, c.failConnection(CloseStatusProtocolError, "invalid close status code")
 			}
 
-			if err := c.handleClose(statusCode, reason); err != nil {
-				return nil, err
+			if !utf8.Valid(f.payload[2:]) {
+				return nil, c.failConnection(CloseStatusInvalidFramePayloadData, "invalid UTF-8 in close reason")
 			}
+		}
+
+		if err := c.handleClose(statusCode, reason); err != nil {
+			return nil, err
+		}
 
-			return nil, ErrConnectionClosed
+		return nil, ErrConnectionClosed
+	case OpPong:
+		c.lastPong.Store(time.Now().UnixNano())
+		if c.pongHandler != nil {
+			if err := c.pongHandler(payload); err != nil {
+				return nil, err
+			}
 		}
 		return nil, nil
 	}
@@ -323,3 +630,24 @@ func (c *Conn) handleClose(statusCode uint16, reason string) error {
 
 	return nil
 }
+
+// failConnectionWriteTimeout bounds how long failConnection waits to
+// send its Close frame before giving up on the peer and closing anyway.
+const failConnectionWriteTimeout = 5 * time.Second
+
+// failConnection sends a Close frame carrying code and text (best
+// effort, since the connection may already be broken or the peer may
+// never read it) and tears down the connection, as required when a
+// protocol violation such as invalid UTF-8 is detected. It returns the
+// CloseError describing why, for the caller to surface to whoever was
+// reading or writing.
+func (c *Conn) failConnection(code uint16, text string) *CloseError {
+	buf := binary.BigEndian.AppendUint16(nil, code)
+	buf = append(buf, text...)
+
+	c.conn.SetWriteDeadline(time.Now().Add(failConnectionWriteTimeout))
+	c.WriteMessage(OpClose, buf)
+	c.Close()
+
+	return &CloseError{Code: code, Text: text}
+}
@@ -4,7 +4,10 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"testing"
 )
@@ -126,7 +129,12 @@ func TestReadFragmentedTextMessage(t *testing.T) {
 				t.Errorf("failed to prepare masked payload")
 			}
 
-			frame := []byte{0x1, 0x80}
+			opcode := byte(0) // every fragment after the first uses the continuation opcode
+			if i == 0 {
+				opcode = OpText
+			}
+
+			frame := []byte{opcode, 0x80}
 
 			if i == len(payloadFragments)-1 {
 				frame[0] |= bitFin
@@ -151,3 +159,189 @@ func TestReadFragmentedTextMessage(t *testing.T) {
 		t.Errorf("expected %s got %s", payload, received)
 	}
 }
+
+func TestWriteMessageExtendedLength(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ws := Conn{
+		conn:     server,
+		reader:   bufio.NewReaderSize(server, 1024),
+		writeBuf: make([]byte, 1024),
+	}
+	defer ws.Close()
+
+	payload := bytes.Repeat([]byte("a"), 200)
+
+	go func() {
+		if err := ws.WriteMessage(OpText, payload); err != nil {
+			t.Error("failed to write the test message", err)
+		}
+	}()
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(client, header); err != nil {
+		t.Fatal("failed to read the frame header", err)
+	}
+
+	if header[0] != 0x81 {
+		t.Errorf("expected FIN|OpText (0x81), got %#x", header[0])
+	}
+
+	if header[1] != 126 {
+		t.Errorf("expected the 16-bit length marker (126), got %d", header[1])
+	}
+
+	if length := binary.BigEndian.Uint16(header[2:4]); length != uint16(len(payload)) {
+		t.Errorf("expected length %d, got %d", len(payload), length)
+	}
+
+	received := make([]byte, len(payload))
+	if _, err := io.ReadFull(client, received); err != nil {
+		t.Fatal("failed to read the frame payload", err)
+	}
+
+	if !bytes.Equal(payload, received) {
+		t.Errorf("expected %s, got %s", payload, received)
+	}
+}
+
+func TestReadRejectsStrayContinuationFrame(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ws := Conn{
+		conn:     server,
+		reader:   bufio.NewReaderSize(server, 1024),
+		writeBuf: make([]byte, 1024),
+	}
+	defer ws.Close()
+
+	payload := []byte("test")
+	maskedPayload, maskingKey, err := maskPayload(payload)
+	if err != nil {
+		t.Error("failed to prepare masked payload", err)
+	}
+
+	// A continuation frame (opcode 0) as the first frame of a message
+	// has nothing to continue and must be rejected.
+	frame := []byte{bitFin, 0x84}
+	frame = append(frame, maskingKey...)
+	frame = append(frame, maskedPayload...)
+
+	go func() {
+		if _, err := client.Write(frame); err != nil {
+			t.Error("failed to write to the WebSocket", err)
+		}
+	}()
+
+	go io.Copy(io.Discard, client)
+
+	_, err = ws.ReadMessage()
+
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("expected a *CloseError, got %T: %v", err, err)
+	}
+
+	if closeErr.Code != CloseStatusProtocolError {
+		t.Errorf("expected close code %d, got %d", CloseStatusProtocolError, closeErr.Code)
+	}
+}
+
+func TestReadRejectsNewMessageMidFragment(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ws := Conn{
+		conn:     server,
+		reader:   bufio.NewReaderSize(server, 1024),
+		writeBuf: make([]byte, 1024),
+	}
+	defer ws.Close()
+
+	first, firstKey, err := maskPayload([]byte("te"))
+	if err != nil {
+		t.Fatal("failed to prepare masked payload", err)
+	}
+
+	second, secondKey, err := maskPayload([]byte("st"))
+	if err != nil {
+		t.Fatal("failed to prepare masked payload", err)
+	}
+
+	go func() {
+		// Non-final OpText fragment...
+		frame := []byte{OpText, 0x82}
+		frame = append(frame, firstKey...)
+		frame = append(frame, first...)
+		if _, err := client.Write(frame); err != nil {
+			t.Error("failed to write to the WebSocket", err)
+		}
+
+		// ...followed by a brand new message instead of its continuation.
+		frame = []byte{bitFin | OpText, 0x82}
+		frame = append(frame, secondKey...)
+		frame = append(frame, second...)
+		if _, err := client.Write(frame); err != nil {
+			t.Error("failed to write to the WebSocket", err)
+		}
+	}()
+
+	go io.Copy(io.Discard, client)
+
+	_, err = ws.ReadMessage()
+
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("expected a *CloseError, got %T: %v", err, err)
+	}
+
+	if closeErr.Code != CloseStatusProtocolError {
+		t.Errorf("expected close code %d, got %d", CloseStatusProtocolError, closeErr.Code)
+	}
+}
+
+func TestReadInvalidUTF8TextMessage(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ws := Conn{
+		conn:     server,
+		reader:   bufio.NewReaderSize(server, 1024),
+		writeBuf: make([]byte, 1024),
+	}
+	defer ws.Close()
+
+	// 0xFF is never valid UTF-8.
+	payload := []byte{0xFF}
+	maskedPayload, maskingKey, err := maskPayload(payload)
+	if err != nil {
+		t.Error("failed to prepare masked payload", err)
+	}
+
+	frame := []byte{0x81, 0x81}
+	frame = append(frame, maskingKey...)
+	frame = append(frame, maskedPayload...)
+
+	go func() {
+		if _, err := client.Write(frame); err != nil {
+			t.Error("failed to write to the WebSocket", err)
+		}
+	}()
+
+	// failConnection echoes a Close frame back; drain it so the write
+	// doesn't block on an unread net.Pipe.
+	go io.Copy(io.Discard, client)
+
+	_, err = ws.ReadMessage()
+
+	var closeErr *CloseError
+	if !errors.As(err, &closeErr) {
+		t.Fatalf("expected a *CloseError, got %T: %v", err, err)
+	}
+
+	if closeErr.Code != CloseStatusInvalidFramePayloadData {
+		t.Errorf("expected close code %d, got %d", CloseStatusInvalidFramePayloadData, closeErr.Code)
+	}
+}
@@ -0,0 +1,231 @@
+package ws
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrBadHandshake = errors.New("client handshake failed")
+)
+
+// Dialer contains options for opening a client-side WebSocket connection.
+type Dialer struct {
+	// NetDial is used to create the underlying TCP connection. If nil,
+	// net.Dialer.DialContext is used.
+	NetDial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// TLSClientConfig is used when dialing a wss:// URL.
+	TLSClientConfig *tls.Config
+
+	// HandshakeTimeout bounds the time spent dialing and exchanging the
+	// opening handshake. Zero means no timeout.
+	HandshakeTimeout time.Duration
+
+	// Subprotocols lists the subprotocols the client is willing to speak,
+	// sent in the Sec-WebSocket-Protocol header.
+	Subprotocols []string
+
+	// EnableCompression enables permessage-deflate negotiation.
+	EnableCompression bool
+
+	ReadBufferSize  int
+	WriteBufferSize int
+}
+
+// DefaultDialer is used by the package-level Dial function.
+var DefaultDialer = &Dialer{}
+
+// Dial is a convenience wrapper around DefaultDialer.Dial.
+func Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	return DefaultDialer.Dial(urlStr, requestHeader)
+}
+
+// Dial opens a WebSocket connection to urlStr, performs the opening
+// handshake and returns the resulting Conn along with the server's
+// handshake response. urlStr must use the ws:// or wss:// scheme.
+func (d *Dialer) Dial(urlStr string, requestHeader http.Header) (*Conn, *http.Response, error) {
+	ctx := context.Background()
+
+	if d.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.HandshakeTimeout)
+		defer cancel()
+	}
+
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	var useTLS bool
+	switch u.Scheme {
+	case "ws":
+		useTLS = false
+	case "wss":
+		useTLS = true
+	default:
+		return nil, nil, fmt.Errorf("unsupported scheme %q: %w", u.Scheme, ErrBadHandshake)
+	}
+
+	addr := u.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		if useTLS {
+			addr = net.JoinHostPort(addr, "443")
+		} else {
+			addr = net.JoinHostPort(addr, "80")
+		}
+	}
+
+	netConn, err := d.dial(ctx, addr, useTLS, u.Hostname())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to dial %s: %w", addr, err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		netConn.SetDeadline(deadline)
+	}
+
+	key, err := generateChallengeKey()
+	if err != nil {
+		netConn.Close()
+		return nil, nil, fmt.Errorf("failed to generate Sec-WebSocket-Key: %w", err)
+	}
+
+	header := requestHeader.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Key", key)
+	header.Set("Sec-WebSocket-Version", "13")
+
+	if len(d.Subprotocols) > 0 {
+		header.Set("Sec-WebSocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
+
+	if d.EnableCompression {
+		header.Set("Sec-WebSocket-Extensions", "permessage-deflate; client_max_window_bits")
+	}
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Host:       u.Host,
+	}
+
+	if err := req.Write(netConn); err != nil {
+		netConn.Close()
+		return nil, nil, fmt.Errorf("failed to write handshake request: %w", err)
+	}
+
+	br := bufio.NewReaderSize(netConn, d.readBufferSize())
+
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		netConn.Close()
+		return nil, nil, fmt.Errorf("failed to read handshake response: %w", err)
+	}
+
+	if res.StatusCode != http.StatusSwitchingProtocols ||
+		!strings.EqualFold(res.Header.Get("Upgrade"), "websocket") ||
+		!strings.EqualFold(res.Header.Get("Connection"), "Upgrade") {
+		netConn.Close()
+		return nil, res, fmt.Errorf("server responded with %q: %w", res.Status, ErrBadHandshake)
+	}
+
+	if res.Header.Get("Sec-WebSocket-Accept") != generateAcceptKey(key) {
+		netConn.Close()
+		return nil, res, fmt.Errorf("invalid Sec-WebSocket-Accept: %w", ErrBadHandshake)
+	}
+
+	c := newConn(netConn, d.readBufferSize(), d.writeBufferSize(), true)
+	c.subprotocol = res.Header.Get("Sec-WebSocket-Protocol")
+
+	if d.EnableCompression {
+		if params, ok := findPermessageDeflateExtension(res.Header.Get("Sec-WebSocket-Extensions")); ok {
+			// From the client's point of view, "own" is the client side
+			// of the negotiated parameters and "peer" is the server side.
+			c.configureCompression(0, params.clientNoContextTakeover, params.serverNoContextTakeover)
+		}
+	}
+
+	netConn.SetDeadline(time.Time{})
+
+	return c, res, nil
+}
+
+// dial establishes the underlying TCP or TLS connection used for the
+// handshake, honoring NetDial and TLSClientConfig when set.
+func (d *Dialer) dial(ctx context.Context, addr string, useTLS bool, serverName string) (net.Conn, error) {
+	dial := d.NetDial
+	if dial == nil {
+		var nd net.Dialer
+		dial = nd.DialContext
+	}
+
+	conn, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if !useTLS {
+		return conn, nil
+	}
+
+	cfg := d.TLSClientConfig
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	if cfg.ServerName == "" {
+		cfg = cfg.Clone()
+		cfg.ServerName = serverName
+	}
+
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+func (d *Dialer) readBufferSize() int {
+	if d.ReadBufferSize > 0 {
+		return d.ReadBufferSize
+	}
+	return 4096
+}
+
+func (d *Dialer) writeBufferSize() int {
+	if d.WriteBufferSize > 0 {
+		return d.WriteBufferSize
+	}
+	return 4096
+}
+
+// generateChallengeKey generates a random 16-byte Sec-WebSocket-Key as
+// described in https://www.rfc-editor.org/rfc/rfc6455#section-4.1
+func generateChallengeKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,152 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// pongTimeoutFactor bounds how long the keepalive goroutine waits for
+// signs of life (a Pong, tracked via lastPong) before giving up on the
+// connection, expressed as a multiple of the ping period.
+const pongTimeoutFactor = 2
+
+// SetReadDeadline sets the deadline for future reads from the
+// underlying connection. A zero value disables the deadline. Once a
+// read times out, every future read fails until SetReadDeadline is
+// called again, per net.Conn's deadline semantics.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for future writes to the
+// underlying connection, following net.Conn.SetWriteDeadline's
+// semantics.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// SetPingHandler sets the function invoked when a Ping control frame
+// arrives; appData is the frame's application data. Passing nil
+// restores the default, which replies with a Pong carrying the same
+// appData.
+func (c *Conn) SetPingHandler(h func(appData []byte) error) {
+	c.pingHandler = h
+}
+
+// SetPongHandler sets the function invoked when a Pong control frame
+// arrives. Passing nil restores the default, which does nothing.
+// Liveness tracking for SetPingPeriod does not go through this handler,
+// so overriding it doesn't affect keepalive.
+func (c *Conn) SetPongHandler(h func(appData []byte) error) {
+	c.pongHandler = h
+}
+
+// SetPingPeriod starts a background goroutine that sends a Ping every
+// period and closes the connection if no Pong has been seen for
+// pongTimeoutFactor*period, treating the peer as unresponsive. Calling
+// it again replaces any keepalive goroutine already running; a period
+// of zero just stops the keepalive.
+func (c *Conn) SetPingPeriod(period time.Duration) {
+	if c.stopKeepalive != nil {
+		close(c.stopKeepalive)
+		c.stopKeepalive = nil
+	}
+
+	if period <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	c.stopKeepalive = stop
+	c.lastPong.Store(time.Now().UnixNano())
+
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if time.Since(time.Unix(0, c.lastPong.Load())) > pongTimeoutFactor*period {
+					c.Close()
+					return
+				}
+
+				if err := c.WriteMessage(OpPing, nil); err != nil {
+					c.Close()
+					return
+				}
+			}
+		}
+	}()
+}
+
+// ReadMessageContext is ReadMessage, except ctx's deadline and
+// cancellation are translated into the connection's read deadline, so
+// a caller can bound or cancel a read without reaching for the
+// underlying net.Conn directly.
+func (c *Conn) ReadMessageContext(ctx context.Context) ([]byte, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetReadDeadline(deadline)
+	} else {
+		c.SetReadDeadline(time.Time{})
+	}
+
+	type result struct {
+		buf []byte
+		err error
+	}
+	done := make(chan result, 1)
+
+	go func() {
+		buf, err := c.ReadMessage()
+		done <- result{buf, err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		// Force the in-flight read to unblock, then wait for it so we
+		// never return before the goroutine above is done touching c.
+		c.SetReadDeadline(time.Now())
+		<-done
+		return nil, fmt.Errorf("read canceled: %w", ctx.Err())
+	case r := <-done:
+		// The read deadline is ctx's own, so a read that hit it means
+		// ctx has expired as well; report it the same way.
+		if _, ok := ctx.Deadline(); ok && errors.Is(r.err, os.ErrDeadlineExceeded) {
+			<-ctx.Done()
+			return nil, fmt.Errorf("read canceled: %w", ctx.Err())
+		}
+		return r.buf, r.err
+	}
+}
+
+// WriteMessageContext is WriteMessage, except ctx's deadline and
+// cancellation are translated into the connection's write deadline.
+func (c *Conn) WriteMessageContext(ctx context.Context, opcode uint8, m []byte) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetWriteDeadline(deadline)
+	} else {
+		c.SetWriteDeadline(time.Time{})
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.WriteMessage(opcode, m)
+	}()
+
+	select {
+	case <-ctx.Done():
+		c.SetWriteDeadline(time.Now())
+		<-done
+		return fmt.Errorf("write canceled: %w", ctx.Err())
+	case err := <-done:
+		return err
+	}
+}
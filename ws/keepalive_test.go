@@ -0,0 +1,78 @@
+package ws
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPingPeriodSendsPing(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ws := &Conn{
+		conn:     server,
+		reader:   bufio.NewReaderSize(server, 1024),
+		writeBuf: make([]byte, 1024),
+	}
+	defer ws.Close()
+
+	ws.SetPingPeriod(10 * time.Millisecond)
+	defer ws.SetPingPeriod(0)
+
+	frame := make([]byte, 2)
+	if _, err := client.Read(frame); err != nil {
+		t.Fatal("failed to read ping frame", err)
+	}
+
+	if opcode := frame[0] & 0xf; opcode != OpPing {
+		t.Errorf("expected opcode %d, got %d", OpPing, opcode)
+	}
+}
+
+func TestSetPingPeriodDoesNotRaceWithWrites(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ws := &Conn{
+		conn:     server,
+		reader:   bufio.NewReaderSize(server, 1024),
+		writeBuf: make([]byte, 1024),
+	}
+	defer ws.Close()
+
+	go io.Copy(io.Discard, client)
+
+	ws.SetPingPeriod(time.Millisecond)
+	defer ws.SetPingPeriod(0)
+
+	for i := 0; i < 100; i++ {
+		if err := ws.WriteMessage(OpText, []byte("hello")); err != nil {
+			return
+		}
+	}
+}
+
+func TestReadMessageContextCancellation(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+
+	ws := &Conn{
+		conn:     server,
+		reader:   bufio.NewReaderSize(server, 1024),
+		writeBuf: make([]byte, 1024),
+	}
+	defer ws.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := ws.ReadMessageContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 )
 
 var (
@@ -23,6 +24,28 @@ type Upgrader struct {
 	// RW buffer sizes
 	ReadBufferSize  int
 	WriteBufferSize int
+
+	// EnableCompression advertises support for permessage-deflate and
+	// accepts it when the client offers it.
+	EnableCompression bool
+
+	// CompressionLevel is passed to flate.NewWriter for connections
+	// that keep write-side context takeover. Zero means
+	// flate.BestCompression.
+	CompressionLevel int
+
+	// ServerNoContextTakeover disables reusing the compression window
+	// across messages the server sends, trading ratio for lower
+	// per-connection memory use.
+	ServerNoContextTakeover bool
+
+	// ClientNoContextTakeover tells the client not to reuse its
+	// compression window across messages it sends.
+	ClientNoContextTakeover bool
+
+	// PingInterval, when non-zero, starts an automatic ping-keepalive
+	// goroutine on every upgraded connection; see Conn.SetPingPeriod.
+	PingInterval time.Duration
 }
 
 // Upgrade upgrades the HTTP connection to use the WebSocket protocol.
@@ -46,6 +69,7 @@ func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error
 	}
 
 	protocol := u.resolveSubprotocol(r)
+	compressionParams, acceptCompression := u.negotiatePermessageDeflate(r)
 
 	// FIXME: Probably we should validate more things
 	// https://www.rfc-editor.org/rfc/rfc6455#section-4.1
@@ -78,6 +102,10 @@ func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error
 		resHeaders.Set("Sec-WebSocket-Prococol", protocol)
 	}
 
+	if acceptCompression {
+		resHeaders.Set("Sec-WebSocket-Extensions", formatPermessageDeflateExtension(compressionParams))
+	}
+
 	res := http.Response{
 		ProtoMajor: 1,
 		ProtoMinor: 1,
@@ -96,7 +124,18 @@ func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error
 		return nil, fmt.Errorf("failed to write to connection: %w", err)
 	}
 
-	return newConn(conn, u.ReadBufferSize, u.WriteBufferSize), nil
+	c := newConn(conn, u.ReadBufferSize, u.WriteBufferSize, false)
+	c.subprotocol = protocol
+
+	if acceptCompression {
+		c.configureCompression(u.CompressionLevel, compressionParams.serverNoContextTakeover, compressionParams.clientNoContextTakeover)
+	}
+
+	if u.PingInterval > 0 {
+		c.SetPingPeriod(u.PingInterval)
+	}
+
+	return c, nil
 }
 
 // resolveSubprotocol finds subprotocol that satisfies both server and the client.
@@ -112,6 +151,26 @@ func (u *Upgrader) resolveSubprotocol(r *http.Request) string {
 	return ""
 }
 
+// negotiatePermessageDeflate inspects the client's Sec-WebSocket-Extensions
+// offer and decides whether to accept permessage-deflate. The returned
+// params additionally reflect this server's own no-context-takeover
+// preferences, which are honored regardless of what the client asked for.
+func (u *Upgrader) negotiatePermessageDeflate(r *http.Request) (params permessageDeflateParams, accept bool) {
+	if !u.EnableCompression {
+		return params, false
+	}
+
+	params, ok := findPermessageDeflateExtension(r.Header.Get("Sec-WebSocket-Extensions"))
+	if !ok {
+		return params, false
+	}
+
+	params.serverNoContextTakeover = params.serverNoContextTakeover || u.ServerNoContextTakeover
+	params.clientNoContextTakeover = params.clientNoContextTakeover || u.ClientNoContextTakeover
+
+	return params, true
+}
+
 // checkHandshake checks if request independent headers meet handshake requirements.
 func (u *Upgrader) checkHandshake(r *http.Request) (error, int) {
 	if r.Method != http.MethodGet {
@@ -135,6 +194,14 @@ func (u *Upgrader) checkHandshake(r *http.Request) (error, int) {
 
 // generateAcceptKey generates a value for Sec-WebSocket-Accept header.
 func (u *Upgrader) generateAcceptKey(key string) string {
+	return generateAcceptKey(key)
+}
+
+// generateAcceptKey computes the Sec-WebSocket-Accept value for key, as
+// described in https://www.rfc-editor.org/rfc/rfc6455#section-1.3. Both
+// the server (above) and the client, when validating the handshake
+// response in Dialer.Dial, use this to derive the expected value.
+func generateAcceptKey(key string) string {
 	// FIXME: It is stated that SHA-1 is cryptographically broken and shouldn't be used (?)
 	// https://pkg.go.dev/crypto/sha1@go1.19.4
 	h := sha1.New()